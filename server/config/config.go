@@ -0,0 +1,205 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package config backs the emulator's live /emulator/config endpoint: a
+// mutex-guarded, fingerprinted view of the running configuration that
+// lets concurrent PATCH requests detect lost updates instead of silently
+// clobbering one another.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Fields is the emulator's effective runtime configuration, as returned by
+// GET /emulator/config and partially updated by PATCH /emulator/config.
+type Fields struct {
+	ServiceKey             string `json:"serviceKey"`
+	ChainID                string `json:"chainId"`
+	TransactionFeesEnabled bool   `json:"transactionFeesEnabled"`
+	StorageLimitEnabled    bool   `json:"storageLimitEnabled"`
+	ContractRemovalEnabled bool   `json:"contractRemovalEnabled"`
+	MinimumAccountBalance  uint64 `json:"minimumAccountBalance"`
+	TransactionExpiry      uint   `json:"transactionExpiry"`
+	ScriptGasLimit         uint64 `json:"scriptGasLimit"`
+}
+
+// immutable lists the JSON-merge-patch paths that cannot change once the
+// blockchain is running, since they are baked into addresses, signatures,
+// or state already produced by it.
+var immutable = map[string]bool{
+	"chainId":    true,
+	"serviceKey": true,
+}
+
+// ErrFingerprintMismatch is returned by DoLockedAction and Patch when the
+// caller's If-Match fingerprint no longer matches the current value.
+var ErrFingerprintMismatch = errors.New("config fingerprint does not match current value")
+
+// ImmutableFieldError reports the JSON-merge-patch paths a PATCH request
+// tried to change that are not allowed to change at runtime.
+type ImmutableFieldError struct {
+	Paths []string
+}
+
+func (e *ImmutableFieldError) Error() string {
+	return fmt.Sprintf("cannot modify immutable config fields: %v", e.Paths)
+}
+
+// Handler holds the live configuration as an in-memory Fields value, guarded
+// by a mutex so GET/PATCH/reload never observe a torn write. There is no
+// separate interface type; callers use *Handler directly.
+type Handler struct {
+	mu     sync.Mutex
+	fields Fields
+	reload func() (Fields, error)
+}
+
+// NewHandler constructs a Handler seeded with initial. reload, if
+// non-nil, is invoked by Reload() to fetch a fresh value from whatever
+// source the caller supplies.
+func NewHandler(initial Fields, reload func() (Fields, error)) *Handler {
+	return &Handler{fields: initial, reload: reload}
+}
+
+// Get returns the current configuration.
+func (h *Handler) Get() Fields {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.fields
+}
+
+// Sync replaces the handler's cached fields with a freshly read value
+// (e.g. GET re-deriving configFields() straight off the running
+// blockchain) and returns its fingerprint, so the ETag a caller hands
+// back to the client stays consistent with what a subsequent PATCH's
+// If-Match check compares against.
+func (h *Handler) Sync(fields Fields) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fields = fields
+	return fingerprintOf(h.fields)
+}
+
+// Fingerprint returns a hash of the current configuration, for use as an
+// If-Match precondition on PATCH.
+func (h *Handler) Fingerprint() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fingerprintOf(h.fields)
+}
+
+// DoLockedAction runs fn with the handler locked, first verifying that
+// fingerprint (if non-empty) still matches the current value.
+func (h *Handler) DoLockedAction(fingerprint string, fn func(*Fields) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if fingerprint != "" && fingerprint != fingerprintOf(h.fields) {
+		return ErrFingerprintMismatch
+	}
+
+	return fn(&h.fields)
+}
+
+// Patch applies a JSON-merge-patch (RFC 7396) to the mutable subset of
+// Fields. It rejects the entire patch with an *ImmutableFieldError if any
+// path attempts to change an immutable field.
+func (h *Handler) Patch(fingerprint string, patch map[string]json.RawMessage) (Fields, error) {
+	var result Fields
+
+	err := h.DoLockedAction(fingerprint, func(fields *Fields) error {
+		var offending []string
+		for path := range patch {
+			if immutable[path] {
+				offending = append(offending, path)
+			}
+		}
+		if len(offending) > 0 {
+			return &ImmutableFieldError{Paths: offending}
+		}
+
+		merged, err := mergePatch(*fields, patch)
+		if err != nil {
+			return err
+		}
+
+		*fields = merged
+		result = merged
+		return nil
+	})
+
+	return result, err
+}
+
+// Reload re-reads the configuration through the reload function passed to
+// NewHandler and replaces the in-memory value wholesale.
+func (h *Handler) Reload() (Fields, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.reload == nil {
+		return Fields{}, fmt.Errorf("no reload source configured")
+	}
+
+	fields, err := h.reload()
+	if err != nil {
+		return Fields{}, err
+	}
+
+	h.fields = fields
+	return h.fields, nil
+}
+
+func fingerprintOf(fields Fields) string {
+	b, _ := json.Marshal(fields)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func mergePatch(fields Fields, patch map[string]json.RawMessage) (Fields, error) {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return Fields{}, err
+	}
+
+	var asMap map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &asMap); err != nil {
+		return Fields{}, err
+	}
+
+	for path, value := range patch {
+		asMap[path] = value
+	}
+
+	merged, err := json.Marshal(asMap)
+	if err != nil {
+		return Fields{}, err
+	}
+
+	var result Fields
+	if err := json.Unmarshal(merged, &result); err != nil {
+		return Fields{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initialFields() Fields {
+	return Fields{
+		ServiceKey:             "key",
+		ChainID:                "emulator",
+		TransactionFeesEnabled: false,
+		ScriptGasLimit:         9999,
+	}
+}
+
+func TestHandlerGetAndFingerprint(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+
+	assert.Equal(t, initialFields(), h.Get())
+	assert.Equal(t, h.Fingerprint(), h.Fingerprint(), "fingerprint must be stable across calls with no writes")
+}
+
+func TestHandlerPatchUpdatesMutableFields(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+	fp := h.Fingerprint()
+
+	patch := map[string]json.RawMessage{"scriptGasLimit": json.RawMessage("123")}
+	fields, err := h.Patch(fp, patch)
+	require.NoError(t, err)
+	assert.EqualValues(t, 123, fields.ScriptGasLimit)
+	assert.Equal(t, fields, h.Get())
+	assert.NotEqual(t, fp, h.Fingerprint(), "a successful patch must change the fingerprint")
+}
+
+func TestHandlerPatchRejectsStaleFingerprint(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+
+	_, err := h.Patch("stale-fingerprint", map[string]json.RawMessage{
+		"scriptGasLimit": json.RawMessage("1"),
+	})
+	assert.ErrorIs(t, err, ErrFingerprintMismatch)
+}
+
+func TestHandlerPatchRejectsImmutableFields(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+	fp := h.Fingerprint()
+
+	_, err := h.Patch(fp, map[string]json.RawMessage{
+		"chainId": json.RawMessage(`"testnet"`),
+	})
+
+	var immutableErr *ImmutableFieldError
+	require.ErrorAs(t, err, &immutableErr)
+	assert.Equal(t, []string{"chainId"}, immutableErr.Paths)
+	assert.Equal(t, fp, h.Fingerprint(), "a rejected patch must not change the fingerprint")
+}
+
+func TestHandlerPatchRejectsWholeRequestIfAnyFieldIsImmutable(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+	fp := h.Fingerprint()
+
+	_, err := h.Patch(fp, map[string]json.RawMessage{
+		"chainId":        json.RawMessage(`"testnet"`),
+		"scriptGasLimit": json.RawMessage("1"),
+	})
+	require.Error(t, err)
+	assert.EqualValues(t, 9999, h.Get().ScriptGasLimit, "the mutable field must not be applied alongside a rejected immutable one")
+}
+
+func TestHandlerReloadWithoutSourceConfigured(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+
+	_, err := h.Reload()
+	assert.Error(t, err)
+}
+
+func TestHandlerReload(t *testing.T) {
+	reloaded := Fields{ServiceKey: "key", ChainID: "emulator", ScriptGasLimit: 555}
+	h := NewHandler(initialFields(), func() (Fields, error) {
+		return reloaded, nil
+	})
+
+	fields, err := h.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, reloaded, fields)
+	assert.Equal(t, reloaded, h.Get())
+}
+
+func TestHandlerReloadPropagatesSourceError(t *testing.T) {
+	wantErr := errors.New("config file missing")
+	h := NewHandler(initialFields(), func() (Fields, error) {
+		return Fields{}, wantErr
+	})
+
+	_, err := h.Reload()
+	assert.ErrorIs(t, err, wantErr)
+	assert.Equal(t, initialFields(), h.Get(), "a failed reload must not clobber the existing fields")
+}
+
+func TestHandlerSyncUpdatesFieldsAndFingerprint(t *testing.T) {
+	h := NewHandler(initialFields(), nil)
+	fp := h.Fingerprint()
+
+	live := Fields{ServiceKey: "key", ChainID: "emulator", ScriptGasLimit: 42}
+	etag := h.Sync(live)
+
+	assert.Equal(t, live, h.Get())
+	assert.Equal(t, etag, h.Fingerprint())
+	assert.NotEqual(t, fp, etag)
+}
@@ -0,0 +1,207 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package export converts the emulator's native Cadence coverage.Report
+// into formats mainstream coverage tooling understands, so results can be
+// ingested by CI providers like Codecov, Coveralls, and SonarQube.
+package export
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/onflow/cadence/runtime/common"
+	"github.com/onflow/cadence/runtime/coverage"
+)
+
+// Format identifies one of the supported coverage export formats.
+type Format string
+
+const (
+	FormatJSON      Format = "json"
+	FormatLCOV      Format = "lcov"
+	FormatCobertura Format = "cobertura"
+)
+
+// ParseFormat resolves a format from an `Accept` header value or a
+// `?format=` query parameter, defaulting to FormatJSON when empty or
+// unrecognized so existing clients keep working unchanged.
+func ParseFormat(value string) Format {
+	switch value {
+	case "text/x-lcov", string(FormatLCOV):
+		return FormatLCOV
+	case "application/xml", string(FormatCobertura):
+		return FormatCobertura
+	default:
+		return FormatJSON
+	}
+}
+
+// PathFor maps a Cadence source location to a stable, filesystem-style
+// path, e.g. "A.0000000000000001.FooContract.cdc", so that the same
+// contract always resolves to the same entry across coverage runs.
+func PathFor(location common.Location) string {
+	if addressLocation, ok := location.(common.AddressLocation); ok {
+		return fmt.Sprintf("A.%s.%s.cdc", addressLocation.Address.Hex(), addressLocation.Name)
+	}
+	return fmt.Sprintf("%s.cdc", location.ID())
+}
+
+type locationEntry struct {
+	path     string
+	coverage *coverage.LocationCoverage
+}
+
+func sortedEntries(report *coverage.CoverageReport) []locationEntry {
+	entries := make([]locationEntry, 0, len(report.Coverage))
+	for location, locCoverage := range report.Coverage {
+		entries = append(entries, locationEntry{
+			path:     PathFor(location),
+			coverage: locCoverage,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+	return entries
+}
+
+// WriteLCOV writes report in the line-based LCOV tracefile format
+// (SF/DA/LF/LH/end_of_record per source file). ctx is checked between
+// source files so a report with many large files can be canceled mid-walk
+// instead of only before it starts.
+func WriteLCOV(ctx context.Context, w io.Writer, report *coverage.CoverageReport) error {
+	for _, entry := range sortedEntries(report) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "SF:%s\n", entry.path); err != nil {
+			return err
+		}
+
+		lines := make([]int, 0, len(entry.coverage.LineHits))
+		for line := range entry.coverage.LineHits {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		hit := 0
+		for _, line := range lines {
+			hits := entry.coverage.LineHits[line]
+			if hits > 0 {
+				hit++
+			}
+			if _, err := fmt.Fprintf(w, "DA:%d,%d\n", line, hits); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type coberturaCoverage struct {
+	XMLName  xml.Name          `xml:"coverage"`
+	Packages coberturaPackages `xml:"packages"`
+}
+
+type coberturaPackages struct {
+	Packages []coberturaPackage `xml:"package"`
+}
+
+type coberturaPackage struct {
+	Name    string           `xml:"name,attr"`
+	Classes coberturaClasses `xml:"classes"`
+}
+
+type coberturaClasses struct {
+	Classes []coberturaClass `xml:"class"`
+}
+
+type coberturaClass struct {
+	Name     string         `xml:"name,attr"`
+	Filename string         `xml:"filename,attr"`
+	Lines    coberturaLines `xml:"lines"`
+}
+
+type coberturaLines struct {
+	Lines []coberturaLine `xml:"line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// WriteCobertura writes report as a Cobertura-compatible XML document. ctx
+// is checked between source files so a report with many large files can be
+// canceled mid-walk instead of only before it starts.
+func WriteCobertura(ctx context.Context, w io.Writer, report *coverage.CoverageReport) error {
+	entries := sortedEntries(report)
+
+	packages := make([]coberturaPackage, 0, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		lines := make([]int, 0, len(entry.coverage.LineHits))
+		for line := range entry.coverage.LineHits {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+
+		coberturaLinesList := make([]coberturaLine, 0, len(lines))
+		for _, line := range lines {
+			coberturaLinesList = append(coberturaLinesList, coberturaLine{
+				Number: line,
+				Hits:   entry.coverage.LineHits[line],
+			})
+		}
+
+		packages = append(packages, coberturaPackage{
+			Name: entry.path,
+			Classes: coberturaClasses{
+				Classes: []coberturaClass{
+					{
+						Name:     entry.path,
+						Filename: entry.path,
+						Lines:    coberturaLines{Lines: coberturaLinesList},
+					},
+				},
+			},
+		})
+	}
+
+	doc := coberturaCoverage{Packages: coberturaPackages{Packages: packages}}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
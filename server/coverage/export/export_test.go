@@ -0,0 +1,106 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/onflow/cadence/runtime/common"
+	"github.com/onflow/cadence/runtime/coverage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func syntheticReport(t *testing.T) *coverage.CoverageReport {
+	location := common.AddressLocation{
+		Address: common.MustBytesToAddress([]byte{0x1}),
+		Name:    "FooContract",
+	}
+
+	return &coverage.CoverageReport{
+		Coverage: map[common.Location]*coverage.LocationCoverage{
+			location: {
+				LineHits: map[int]int{1: 3, 2: 0, 3: 1},
+			},
+		},
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	report := syntheticReport(t)
+
+	var buf bytes.Buffer
+	err := WriteLCOV(context.Background(), &buf, report)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "SF:A.0000000000000001.FooContract.cdc\n"))
+	assert.Contains(t, out, "DA:1,3\n")
+	assert.Contains(t, out, "DA:2,0\n")
+	assert.Contains(t, out, "LF:3\nLH:2\n")
+	assert.Contains(t, out, "end_of_record\n")
+}
+
+func TestWriteLCOVCanceled(t *testing.T) {
+	report := syntheticReport(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	err := WriteLCOV(ctx, &buf, report)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestWriteCobertura(t *testing.T) {
+	report := syntheticReport(t)
+
+	var buf bytes.Buffer
+	err := WriteCobertura(context.Background(), &buf, report)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "<coverage>")
+	assert.Contains(t, out, `filename="A.0000000000000001.FooContract.cdc"`)
+	assert.Contains(t, out, `<line number="1" hits="3">`)
+}
+
+func TestWriteCoberturaCanceled(t *testing.T) {
+	report := syntheticReport(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	err := WriteCobertura(ctx, &buf, report)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestParseFormat(t *testing.T) {
+	assert.Equal(t, FormatLCOV, ParseFormat("text/x-lcov"))
+	assert.Equal(t, FormatLCOV, ParseFormat("lcov"))
+	assert.Equal(t, FormatCobertura, ParseFormat("application/xml"))
+	assert.Equal(t, FormatCobertura, ParseFormat("cobertura"))
+	assert.Equal(t, FormatJSON, ParseFormat(""))
+	assert.Equal(t, FormatJSON, ParseFormat("application/json"))
+}
@@ -0,0 +1,248 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	maxRequestTimeout     = 5 * time.Minute
+)
+
+// deadlineTimer composes a request's own context with a server-side
+// timeout and the server's global shutdown channel, so a handler can
+// select on a single Done() channel regardless of which of the three
+// fires first: client disconnect, timeout, or server shutdown.
+type deadlineTimer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDeadlineTimer(parent context.Context, timeout time.Duration, shutdown <-chan struct{}) *deadlineTimer {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	t := &deadlineTimer{ctx: ctx, cancel: cancel}
+
+	if shutdown != nil {
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return t
+}
+
+func (t *deadlineTimer) Context() context.Context { return t.ctx }
+func (t *deadlineTimer) Stop()                    { t.cancel() }
+
+type deadlineError struct {
+	Message string `json:"message"`
+}
+
+// DeadlineMiddleware bounds every request by a timeout read from the
+// `X-Request-Timeout` header or `?timeout=` query parameter (seconds),
+// capped at the server's configured maximum, and ties that timeout to the
+// server's shutdown channel so Shutdown() cancels in-flight requests rather
+// than waiting on them forever. The handler runs against a context.Context
+// that expires at the deadline; handlers that thread r.Context() into
+// backend.Backend calls are canceled along with it. If the handler has not
+// written a response by the deadline, the client receives a 503 with a
+// structured JSON error instead of the connection hanging.
+//
+// The /emulator/ws route is exempted from the buffering below: it needs
+// to hijack the underlying connection to upgrade to WebSocket, which
+// bufferedResponseWriter cannot support (it only implements
+// http.ResponseWriter), so wsUpgrader.Upgrade would fail on every
+// connection. That route is not bounded by the request timeout, since a
+// stream is long-lived; its context is canceled by server shutdown, and it
+// writes to the real ResponseWriter.
+func (m *EmulatorAPIServer) DeadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := requestTimeout(r, m.maxRequestTimeout())
+
+		timer := newDeadlineTimer(r.Context(), timeout, m.shutdown)
+		defer timer.Stop()
+
+		if isWebsocketUpgrade(r) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-m.shutdown:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			m.inflight.Add(1)
+			defer m.inflight.Done()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		buf := &bufferedResponseWriter{header: make(http.Header)}
+		done := make(chan struct{})
+
+		m.inflight.Add(1)
+		go func() {
+			defer m.inflight.Done()
+			defer close(done)
+			next.ServeHTTP(buf, r.WithContext(timer.Context()))
+		}()
+
+		select {
+		case <-done:
+			buf.flushTo(w)
+		case <-timer.Context().Done():
+			buf.abort()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(deadlineError{Message: "request exceeded its deadline"})
+		}
+	})
+}
+
+// isWebsocketUpgrade reports whether r was routed to the /emulator/ws
+// endpoint, which needs the real, hijack-capable http.ResponseWriter
+// rather than the buffered one DeadlineMiddleware otherwise uses.
+func isWebsocketUpgrade(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	pattern, err := route.GetPathTemplate()
+	return err == nil && pattern == "/emulator/ws"
+}
+
+func (m *EmulatorAPIServer) maxRequestTimeout() time.Duration {
+	if m.requestTimeoutMax > 0 {
+		return m.requestTimeoutMax
+	}
+	return maxRequestTimeout
+}
+
+// Shutdown signals every in-flight request to cancel via its deadline
+// timer's shutdown channel, then blocks until they have returned (or been
+// canceled). Callers that stop the gRPC server and storage backend (e.g.
+// EmulatorServer.Stop()) must call this first, so no handler is left
+// reading from either after they close. EmulatorServer.Stop() does not
+// call it.
+func (m *EmulatorAPIServer) Shutdown() {
+	m.shutdownOnce.Do(func() {
+		close(m.shutdown)
+	})
+	m.inflight.Wait()
+}
+
+func requestTimeout(r *http.Request, max time.Duration) time.Duration {
+	timeout := defaultRequestTimeout
+
+	raw := r.Header.Get("X-Request-Timeout")
+	if raw == "" {
+		raw = r.URL.Query().Get("timeout")
+	}
+
+	if raw != "" {
+		if seconds, err := strconv.Atoi(raw); err == nil && seconds > 0 {
+			timeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if timeout > max {
+		timeout = max
+	}
+	return timeout
+}
+
+// bufferedResponseWriter defers a handler's writes until it finishes, so
+// that a handler which runs past its deadline can have its eventual
+// writes discarded instead of racing with the 503 response the deadline
+// middleware has already sent to the real http.ResponseWriter.
+type bufferedResponseWriter struct {
+	header      http.Header
+	mu          sync.Mutex
+	buf         []byte
+	statusCode  int
+	wroteHeader bool
+	aborted     bool
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wroteHeader || b.aborted {
+		return
+	}
+	b.wroteHeader = true
+	b.statusCode = statusCode
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.aborted {
+		return len(p), nil
+	}
+	if !b.wroteHeader {
+		b.wroteHeader = true
+		b.statusCode = http.StatusOK
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *bufferedResponseWriter) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.aborted = true
+}
+
+func (b *bufferedResponseWriter) flushTo(w http.ResponseWriter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.aborted {
+		return
+	}
+
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.wroteHeader {
+		w.WriteHeader(b.statusCode)
+	}
+	if len(b.buf) > 0 {
+		_, _ = w.Write(b.buf)
+	}
+}
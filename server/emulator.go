@@ -19,12 +19,19 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	fvmerrors "github.com/onflow/flow-go/fvm/errors"
+	flowgo "github.com/onflow/flow-go/model/flow"
 
 	flowsdk "github.com/onflow/flow-go-sdk"
 
@@ -33,6 +40,9 @@ import (
 	"golang.org/x/exp/slices"
 
 	"github.com/onflow/flow-emulator/server/backend"
+	emulatorconfig "github.com/onflow/flow-emulator/server/config"
+	"github.com/onflow/flow-emulator/server/coverage/export"
+	"github.com/onflow/flow-emulator/server/middleware"
 )
 
 type BlockResponse struct {
@@ -42,29 +52,69 @@ type BlockResponse struct {
 }
 
 type EmulatorAPIServer struct {
-	router  *mux.Router
-	server  *EmulatorServer
-	backend *backend.Backend
+	router    *mux.Router
+	server    *EmulatorServer
+	backend   *backend.Backend
+	broker    *Broker
+	snapshots *snapshotRegistry
+	config    *emulatorconfig.Handler
+
+	requestTimeoutMax time.Duration
+	shutdown          chan struct{}
+	shutdownOnce      sync.Once
+	inflight          sync.WaitGroup
 }
 
-func NewEmulatorAPIServer(server *EmulatorServer, backend *backend.Backend) *EmulatorAPIServer {
+// NewEmulatorAPIServer constructs the REST/WebSocket API server. authConfig
+// selects the authentication mode (none, bearer, basic, or HMAC) applied to
+// mutating endpoints such as /emulator/rollback and /emulator/snapshots; it
+// defaults to middleware.ModeNone, preserving the previous unauthenticated
+// behaviour, when left zero-valued.
+func NewEmulatorAPIServer(server *EmulatorServer, backend *backend.Backend, authConfig middleware.Config) *EmulatorAPIServer {
 	router := mux.NewRouter().StrictSlash(true)
 	r := &EmulatorAPIServer{router: router,
-		server:  server,
-		backend: backend,
+		server:    server,
+		backend:   backend,
+		broker:    NewBroker(),
+		snapshots: newSnapshotRegistry(),
+		shutdown:  make(chan struct{}),
 	}
+	r.config = emulatorconfig.NewHandler(r.configFields(), func() (emulatorconfig.Fields, error) {
+		return r.configFields(), nil
+	})
+
+	router.Use(r.DeadlineMiddleware)
+
+	auth := middleware.New(authConfig)
+	router.Use(auth.Middleware(func(req *http.Request) string {
+		route := mux.CurrentRoute(req)
+		if route == nil {
+			return middleware.RouteKey(req.Method, req.URL.Path)
+		}
+		pattern, err := route.GetPathTemplate()
+		if err != nil {
+			return middleware.RouteKey(req.Method, req.URL.Path)
+		}
+		return middleware.RouteKey(req.Method, pattern)
+	}))
 
 	router.HandleFunc("/emulator/newBlock", r.CommitBlock)
 
+	registerWebsocketRoutes(router, r)
+
 	router.HandleFunc("/emulator/rollback", r.Rollback).Methods("POST")
 
 	router.HandleFunc("/emulator/snapshots", r.SnapshotCreate).Methods("POST")
 	router.HandleFunc("/emulator/snapshots", r.SnapshotList).Methods("GET")
 	router.HandleFunc("/emulator/snapshots/{name}", r.SnapshotJump).Methods("PUT")
+	router.HandleFunc("/emulator/snapshots/{name}/export", r.SnapshotExport).Methods("GET")
+	router.HandleFunc("/emulator/snapshots/import", r.SnapshotImport).Methods("POST")
 
 	router.HandleFunc("/emulator/storages/{address}", r.Storage)
 
-	router.HandleFunc("/emulator/config", r.Config)
+	router.HandleFunc("/emulator/config", r.Config).Methods("GET")
+	router.HandleFunc("/emulator/config", r.ConfigPatch).Methods("PATCH")
+	router.HandleFunc("/emulator/config/reload", r.ConfigReload).Methods("POST")
 
 	router.HandleFunc("/emulator/codeCoverage", r.CodeCoverage).Methods("GET")
 	router.HandleFunc("/emulator/codeCoverage/reset", r.ResetCodeCoverage).Methods("PUT")
@@ -76,17 +126,111 @@ func (m EmulatorAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	m.router.ServeHTTP(w, r)
 }
 
+// configFields reads the effective configuration straight off the running
+// blockchain, so GET and reload always reflect its current state rather
+// than a value computed once at startup.
+func (m EmulatorAPIServer) configFields() emulatorconfig.Fields {
+	blockchain := m.server.blockchain
+	cfg := blockchain.Config()
+
+	return emulatorconfig.Fields{
+		ServiceKey:             blockchain.ServiceKey().PublicKey.String(),
+		ChainID:                fmt.Sprint(cfg.ChainID),
+		TransactionFeesEnabled: cfg.TransactionFeesEnabled,
+		StorageLimitEnabled:    cfg.StorageLimitEnabled,
+		ContractRemovalEnabled: cfg.ContractRemovalEnabled,
+		MinimumAccountBalance:  uint64(cfg.MinimumAccountBalance),
+		TransactionExpiry:      uint(cfg.TransactionExpiry),
+		ScriptGasLimit:         uint64(cfg.ScriptGasLimit),
+	}
+}
+
+// applyConfigFields pushes the mutable fields of fields into the running
+// blockchain so a successful PATCH actually changes its behavior instead
+// of only updating the handler's in-memory copy. ChainID and ServiceKey are
+// left untouched here: Patch already rejects any attempt to change them.
+func (m EmulatorAPIServer) applyConfigFields(fields emulatorconfig.Fields) {
+	blockchain := m.server.blockchain
+	blockchain.SetTransactionFeesEnabled(fields.TransactionFeesEnabled)
+	blockchain.SetStorageLimitEnabled(fields.StorageLimitEnabled)
+	blockchain.SetContractRemovalEnabled(fields.ContractRemovalEnabled)
+	blockchain.SetMinimumAccountBalance(fields.MinimumAccountBalance)
+	blockchain.SetTransactionExpiry(fields.TransactionExpiry)
+	blockchain.SetScriptGasLimit(fields.ScriptGasLimit)
+}
+
 func (m EmulatorAPIServer) Config(w http.ResponseWriter, _ *http.Request) {
-	type ConfigInfo struct {
-		ServiceKey string `json:"service_key"`
+	w.Header().Set("Content-Type", "application/json")
+
+	fields := m.configFields()
+	w.Header().Set("ETag", m.config.Sync(fields))
+
+	s, _ := json.MarshalIndent(fields, "", "\t")
+	_, _ = w.Write(s)
+}
+
+// ConfigPatch applies a JSON-merge-patch of mutable config fields. The
+// request must carry an If-Match header matching the current fingerprint
+// (returned as the Config handler's ETag), or it is rejected with 412 to
+// avoid silently clobbering a concurrent writer's update. Attempts to
+// change an immutable field (e.g. chainId) are rejected with 409 and a
+// JSON error listing the offending paths.
+func (m EmulatorAPIServer) ConfigPatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
 	}
 
-	c := ConfigInfo{
-		ServiceKey: m.server.blockchain.ServiceKey().PublicKey.String(),
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	s, _ := json.MarshalIndent(c, "", "\t")
-	_, _ = w.Write(s)
+	fields, err := m.config.Patch(ifMatch, patch)
+	if err != nil {
+		var immutableErr *emulatorconfig.ImmutableFieldError
+		switch {
+		case errors.As(err, &immutableErr):
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(struct {
+				Message string   `json:"message"`
+				Paths   []string `json:"paths"`
+			}{Message: immutableErr.Error(), Paths: immutableErr.Paths})
+		case errors.Is(err, emulatorconfig.ErrFingerprintMismatch):
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	m.applyConfigFields(fields)
+
+	w.Header().Set("ETag", m.config.Fingerprint())
+	_ = json.NewEncoder(w).Encode(fields)
+}
+
+// ConfigReload re-syncs the in-memory configuration from the running
+// blockchain. There is no on-disk config file reachable from this package
+// (the emulator's CLI config loading lives outside server/), so reload
+// re-derives the effective configuration the same way GET does rather
+// than re-parsing a file; once the config file loader is threaded through
+// EmulatorServer, this should call into it instead.
+func (m EmulatorAPIServer) ConfigReload(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fields, err := m.config.Reload()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", m.config.Fingerprint())
+	_ = json.NewEncoder(w).Encode(fields)
 }
 
 func (m EmulatorAPIServer) CommitBlock(w http.ResponseWriter, r *http.Request) {
@@ -104,6 +248,14 @@ func (m EmulatorAPIServer) CommitBlock(w http.ResponseWriter, r *http.Request) {
 		BlockId: header.ID().String(),
 	}
 
+	m.broker.Publish(TopicBlocks, Frame{
+		Type:    "block.committed",
+		Data:    blockResponse,
+		Height:  header.Height,
+		BlockId: header.ID().String(),
+	})
+	m.publishTransactionsAndEvents(r.Context(), header)
+
 	err = json.NewEncoder(w).Encode(blockResponse)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -112,6 +264,44 @@ func (m EmulatorAPIServer) CommitBlock(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// publishTransactionsAndEvents fans out a transaction.sealed frame for every
+// transaction in the just-committed block, and an event.emitted frame for
+// every Cadence event it produced, to the websocket broker's
+// TopicTransactions and TopicEvents subscribers respectively. CommitBlock is
+// the only point in this server that seals a block, so it is the only place
+// these two topics can be fed from.
+func (m EmulatorAPIServer) publishTransactionsAndEvents(ctx context.Context, header *flowgo.Header) {
+	results, err := m.backend.GetTransactionResultsByBlockID(ctx, header.ID())
+	if err != nil {
+		return
+	}
+
+	for _, result := range results {
+		m.broker.Publish(TopicTransactions, Frame{
+			Type:    "transaction.sealed",
+			Data:    result,
+			Height:  header.Height,
+			BlockId: header.ID().String(),
+		})
+	}
+
+	blockEvents, err := m.backend.GetEventsForHeightRange(ctx, "", header.Height, header.Height)
+	if err != nil {
+		return
+	}
+
+	for _, be := range blockEvents {
+		for _, event := range be.Events {
+			m.broker.Publish(TopicEvents, Frame{
+				Type:    "event.emitted",
+				Data:    event,
+				Height:  header.Height,
+				BlockId: header.ID().String(),
+			})
+		}
+	}
+}
+
 func (m EmulatorAPIServer) Rollback(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.FormValue("height") == "" {
@@ -141,7 +331,12 @@ func (m EmulatorAPIServer) SnapshotList(w http.ResponseWriter, _ *http.Request)
 		return
 	}
 
-	bytes, err := json.Marshal(snapshots)
+	infos := make([]*SnapshotInfo, 0, len(snapshots))
+	for _, name := range snapshots {
+		infos = append(infos, m.snapshots.infoFor(name))
+	}
+
+	bytes, err := json.Marshal(infos)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -152,9 +347,9 @@ func (m EmulatorAPIServer) SnapshotList(w http.ResponseWriter, _ *http.Request)
 
 }
 
-func (m EmulatorAPIServer) latestBlockResponse(name string, w http.ResponseWriter) {
+func (m EmulatorAPIServer) latestBlockResponse(name string, w http.ResponseWriter, r *http.Request) {
 
-	block, _, err := m.backend.GetLatestBlock(context.Background(), true)
+	block, _, err := m.backend.GetLatestBlock(r.Context(), true)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -193,18 +388,33 @@ func (m EmulatorAPIServer) SnapshotJump(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if ifMatch != m.snapshots.infoFor(name).Fingerprint {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	}
+
 	err = m.backend.Emulator().LoadSnapshot(name)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	m.latestBlockResponse(name, w)
+	m.latestBlockResponse(name, w, r)
 }
 
 func (m EmulatorAPIServer) SnapshotCreate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	name := r.FormValue("name")
+
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	meta := decodeSnapshotMetadataRequest(body)
+
+	name := meta.Name
+	if name == "" {
+		name = r.FormValue("name")
+	}
 
 	if name == "" {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -226,7 +436,101 @@ func (m EmulatorAPIServer) SnapshotCreate(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	m.latestBlockResponse(name, w)
+
+	block, _, err := m.backend.GetLatestBlock(r.Context(), true)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	blockId := block.Header.ID().String()
+	m.snapshots.save(&SnapshotInfo{
+		Name:        name,
+		Height:      block.Header.Height,
+		BlockId:     blockId,
+		CreatedAt:   time.Now(),
+		Description: meta.Description,
+		Tags:        meta.Tags,
+		Parent:      meta.Parent,
+		Fingerprint: fingerprint(blockId, meta.Description, meta.Tags, meta.Parent),
+	})
+
+	m.latestBlockResponse(name, w, r)
+}
+
+// SnapshotExport streams a deterministic tar/gzip archive containing the
+// snapshot's metadata alongside its underlying state, so it can be moved
+// between machines or committed as a test fixture.
+func (m EmulatorAPIServer) SnapshotExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	snapshots, err := m.backend.Emulator().Snapshots()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !slices.Contains(snapshots, name) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Context().Err() != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// ExportSnapshot reads the named snapshot's storage tree back out of
+	// the storage backend, so the archive carries real state rather than
+	// just the name the snapshot is addressed by.
+	state, err := m.backend.Emulator().ExportSnapshot(name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, name))
+
+	if err := writeSnapshotArchive(w, m.snapshots.infoFor(name), state); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// SnapshotImport rehydrates a snapshot previously produced by
+// SnapshotExport, restoring both its metadata and its underlying storage
+// tree rather than re-labeling whatever state the receiving process
+// happens to be running.
+func (m EmulatorAPIServer) SnapshotImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	info, state, err := readSnapshotArchive(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := m.backend.Emulator().Snapshots()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if slices.Contains(snapshots, info.Name) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// ImportSnapshot writes the archive's storage tree into the backend
+	// under info.Name, rather than CreateSnapshot labeling the currently
+	// running chain's state with the imported name.
+	if err := m.backend.Emulator().ImportSnapshot(info.Name, state); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	m.snapshots.save(info)
+	m.latestBlockResponse(info.Name, w, r)
 }
 
 func (m EmulatorAPIServer) Storage(w http.ResponseWriter, r *http.Request) {
@@ -236,13 +540,21 @@ func (m EmulatorAPIServer) Storage(w http.ResponseWriter, r *http.Request) {
 
 	addr := flowsdk.HexToAddress(address)
 
-	accountStorage, err := m.backend.GetAccountStorage(addr)
+	if r.Context().Err() != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	accountStorage, err := m.backend.GetAccountStorage(r.Context(), addr)
 	if err != nil {
-		if fvmerrors.IsAccountNotFoundError(err) {
+		switch {
+		case fvmerrors.IsAccountNotFoundError(err):
 			w.WriteHeader(http.StatusNotFound)
-			return
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
 		}
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -253,14 +565,43 @@ func (m EmulatorAPIServer) Storage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CodeCoverage returns the emulator's coverage report, negotiated between
+// its native JSON shape and the LCOV/Cobertura formats mainstream CI tools
+// understand. The format is chosen from `?format=` if present, falling
+// back to the `Accept` header, and defaults to JSON otherwise.
 func (m EmulatorAPIServer) CodeCoverage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	if r.Context().Err() != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	blockchain := m.server.blockchain
+	report := blockchain.CoverageReport()
 
-	err := json.NewEncoder(w).Encode(blockchain.CoverageReport())
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = r.Header.Get("Accept")
+	}
+
+	switch export.ParseFormat(format) {
+	case export.FormatLCOV:
+		w.Header().Set("Content-Type", "text/x-lcov")
+		if err := export.WriteLCOV(r.Context(), w, report); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case export.FormatCobertura:
+		w.Header().Set("Content-Type", "application/xml")
+		if err := export.WriteCobertura(r.Context(), w, report); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
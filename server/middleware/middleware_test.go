@@ -0,0 +1,205 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestRouteKey(t *testing.T) {
+	assert.Equal(t, "GET /emulator/config", RouteKey("GET", "/emulator/config"))
+	assert.NotEqual(t, RouteKey("GET", "/emulator/config"), RouteKey("PATCH", "/emulator/config"))
+}
+
+func TestMiddlewareModeNoneAllowsEverything(t *testing.T) {
+	a := New(Config{Mode: ModeNone})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("POST", "/emulator/rollback")
+	})(okHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMiddlewareOpenRouteBypassesAuth(t *testing.T) {
+	a := New(Config{Mode: ModeBearer, BearerToken: "secret"})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("GET", "/emulator/config")
+	})(okHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emulator/config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMiddlewareBearer(t *testing.T) {
+	a := New(Config{
+		Mode:        ModeBearer,
+		BearerToken: "secret",
+		Policy:      Policy{RouteKey("POST", "/emulator/rollback"): AccessProtected},
+	})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("POST", "/emulator/rollback")
+	})(okHandler())
+
+	req := httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMiddlewareBasic(t *testing.T) {
+	a := New(Config{
+		Mode:          ModeBasic,
+		BasicUsername: "admin",
+		BasicPassword: "hunter2",
+		Policy:        Policy{RouteKey("POST", "/emulator/rollback"): AccessProtected},
+	})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("POST", "/emulator/rollback")
+	})(okHandler())
+
+	req := httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	req.SetBasicAuth("admin", "wrong")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	req.SetBasicAuth("admin", "hunter2")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func signHMAC(t *testing.T, secret []byte, timestamp, nonce, path string) string {
+	t.Helper()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "." + path))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+func newHMACRequest(t *testing.T, secret []byte, nonce string) *http.Request {
+	t.Helper()
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	req := httptest.NewRequest(http.MethodPost, "/emulator/rollback", nil)
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Nonce", nonce)
+	req.Header.Set("X-Signature", signHMAC(t, secret, timestamp, nonce, req.URL.Path))
+	return req
+}
+
+func TestMiddlewareHMAC(t *testing.T) {
+	secret := []byte("top-secret")
+	a := New(Config{
+		Mode:       ModeHMAC,
+		HMACSecret: secret,
+		Policy:     Policy{RouteKey("POST", "/emulator/rollback"): AccessProtected},
+	})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("POST", "/emulator/rollback")
+	})(okHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newHMACRequest(t, secret, "nonce-1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Replaying the same nonce must be rejected.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newHMACRequest(t, secret, "nonce-1"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A bad signature must be rejected regardless of nonce freshness.
+	req := newHMACRequest(t, secret, "nonce-2")
+	req.Header.Set("X-Signature", "deadbeef")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The nonce from the rejected request above must still be usable.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newHMACRequest(t, secret, "nonce-2"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestMiddlewareHMACNonceReplayUnderConcurrency fires the same signed
+// request many times in parallel and asserts exactly one attempt
+// succeeds, guarding checkAndRememberNonce's atomicity.
+func TestMiddlewareHMACNonceReplayUnderConcurrency(t *testing.T) {
+	secret := []byte("top-secret")
+	a := New(Config{
+		Mode:       ModeHMAC,
+		HMACSecret: secret,
+		Policy:     Policy{RouteKey("POST", "/emulator/rollback"): AccessProtected},
+	})
+	handler := a.Middleware(func(*http.Request) string {
+		return RouteKey("POST", "/emulator/rollback")
+	})(okHandler())
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	var successes int32
+	var mu sync.Mutex
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newHMACRequest(t, secret, "shared-nonce"))
+			if rec.Code == http.StatusOK {
+				mu.Lock()
+				successes++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, 1, successes)
+}
@@ -0,0 +1,214 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SnapshotInfo describes a named snapshot together with the metadata
+// recorded when it was taken. It is what /emulator/snapshots now returns
+// in place of a bare name.
+type SnapshotInfo struct {
+	Name        string    `json:"name"`
+	Height      uint64    `json:"height"`
+	BlockId     string    `json:"blockId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// snapshotRegistry tracks the metadata layered on top of the underlying
+// emulator snapshots, which are otherwise addressed by name alone.
+type snapshotRegistry struct {
+	mu    sync.Mutex
+	infos map[string]*SnapshotInfo
+}
+
+func newSnapshotRegistry() *snapshotRegistry {
+	return &snapshotRegistry{
+		infos: make(map[string]*SnapshotInfo),
+	}
+}
+
+// fingerprint hashes the state root (here, the block ID the snapshot was
+// taken at) together with its mutable metadata, so that any change to
+// either invalidates optimistic-concurrency checks based on it.
+func fingerprint(blockId string, description string, tags []string, parent string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%v", blockId, description, parent, tags)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (reg *snapshotRegistry) save(info *SnapshotInfo) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.infos[info.Name] = info
+}
+
+func (reg *snapshotRegistry) get(name string) (*SnapshotInfo, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	info, ok := reg.infos[name]
+	return info, ok
+}
+
+// infoFor returns the recorded metadata for name, falling back to a
+// minimal SnapshotInfo for snapshots that exist in the backend but predate
+// (or were created outside of) the metadata-aware endpoints.
+func (reg *snapshotRegistry) infoFor(name string) *SnapshotInfo {
+	if info, ok := reg.get(name); ok {
+		return info
+	}
+	return &SnapshotInfo{
+		Name:        name,
+		Fingerprint: fingerprint(name, "", nil, ""),
+	}
+}
+
+// snapshotMetadataRequest is the optional JSON body accepted by
+// SnapshotCreate, layered on top of the existing `name` form value.
+type snapshotMetadataRequest struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+}
+
+func decodeSnapshotMetadataRequest(body []byte) snapshotMetadataRequest {
+	var req snapshotMetadataRequest
+	if len(body) == 0 {
+		return req
+	}
+	_ = json.Unmarshal(body, &req)
+	return req
+}
+
+// snapshotExportArchive is the metadata.json entry embedded alongside the
+// exported storage tree in the tar/gzip archive produced by
+// EmulatorAPIServer.SnapshotExport.
+type snapshotExportArchive struct {
+	Info SnapshotInfo `json:"info"`
+}
+
+const (
+	snapshotArchiveMetadataEntry = "metadata.json"
+	snapshotArchiveStateEntry    = "state.bin"
+)
+
+// writeSnapshotArchive writes a deterministic tar/gzip archive containing
+// the snapshot's metadata.json and its underlying state (read in full from
+// state) to w. Archive entries are written in a fixed order and with a
+// fixed ModTime so that exporting the same snapshot twice produces
+// byte-identical output for the same underlying state.
+func writeSnapshotArchive(w io.Writer, info *SnapshotInfo, state []byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	metadata, err := json.Marshal(snapshotExportArchive{Info: *info})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range []struct {
+		name string
+		body []byte
+	}{
+		{snapshotArchiveMetadataEntry, metadata},
+		{snapshotArchiveStateEntry, state},
+	} {
+		header := &tar.Header{
+			Name: entry.name,
+			Mode: 0644,
+			Size: int64(len(entry.body)),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.body); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readSnapshotArchive reverses writeSnapshotArchive, returning the
+// SnapshotInfo recorded in the archive's metadata.json entry together
+// with the raw state bytes from its state.bin entry.
+func readSnapshotArchive(r io.Reader) (*SnapshotInfo, []byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gz.Close()
+
+	var info *SnapshotInfo
+	var state []byte
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			return nil, nil, err
+		}
+
+		switch header.Name {
+		case snapshotArchiveMetadataEntry:
+			var archive snapshotExportArchive
+			if err := json.Unmarshal(buf.Bytes(), &archive); err != nil {
+				return nil, nil, err
+			}
+			info = &archive.Info
+		case snapshotArchiveStateEntry:
+			state = buf.Bytes()
+		}
+	}
+
+	if info == nil {
+		return nil, nil, fmt.Errorf("archive missing %s entry", snapshotArchiveMetadataEntry)
+	}
+	if state == nil {
+		return nil, nil, fmt.Errorf("archive missing %s entry", snapshotArchiveStateEntry)
+	}
+
+	return info, state, nil
+}
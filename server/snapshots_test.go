@@ -0,0 +1,120 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintChangesWithMetadata(t *testing.T) {
+	base := fingerprint("block-1", "a snapshot", []string{"tag"}, "")
+
+	assert.NotEqual(t, base, fingerprint("block-2", "a snapshot", []string{"tag"}, ""))
+	assert.NotEqual(t, base, fingerprint("block-1", "different", []string{"tag"}, ""))
+	assert.NotEqual(t, base, fingerprint("block-1", "a snapshot", []string{"other"}, ""))
+	assert.NotEqual(t, base, fingerprint("block-1", "a snapshot", []string{"tag"}, "parent"))
+	assert.Equal(t, base, fingerprint("block-1", "a snapshot", []string{"tag"}, ""))
+}
+
+func TestSnapshotRegistrySaveAndGet(t *testing.T) {
+	reg := newSnapshotRegistry()
+
+	_, ok := reg.get("unknown")
+	assert.False(t, ok)
+
+	info := &SnapshotInfo{Name: "mainnet-fork", BlockId: "block-1"}
+	reg.save(info)
+
+	got, ok := reg.get("mainnet-fork")
+	require.True(t, ok)
+	assert.Equal(t, info, got)
+}
+
+func TestSnapshotRegistryInfoForFallsBackForUnknownNames(t *testing.T) {
+	reg := newSnapshotRegistry()
+
+	info := reg.infoFor("predates-metadata")
+	assert.Equal(t, "predates-metadata", info.Name)
+	assert.Equal(t, fingerprint("predates-metadata", "", nil, ""), info.Fingerprint)
+
+	saved := &SnapshotInfo{Name: "has-metadata", Description: "tagged"}
+	reg.save(saved)
+	assert.Same(t, saved, reg.infoFor("has-metadata"))
+}
+
+func TestDecodeSnapshotMetadataRequest(t *testing.T) {
+	assert.Equal(t, snapshotMetadataRequest{}, decodeSnapshotMetadataRequest(nil))
+
+	req := decodeSnapshotMetadataRequest([]byte(`{"name":"n","description":"d","tags":["a","b"],"parent":"p"}`))
+	assert.Equal(t, snapshotMetadataRequest{Name: "n", Description: "d", Tags: []string{"a", "b"}, Parent: "p"}, req)
+
+	// Malformed JSON is ignored rather than propagated, matching the
+	// best-effort decoding SnapshotCreate relies on.
+	assert.Equal(t, snapshotMetadataRequest{}, decodeSnapshotMetadataRequest([]byte("not json")))
+}
+
+func TestSnapshotArchiveRoundTrip(t *testing.T) {
+	info := &SnapshotInfo{
+		Name:        "mainnet-fork",
+		Height:      42,
+		BlockId:     "block-42",
+		CreatedAt:   time.Unix(1700000000, 0).UTC(),
+		Description: "before the migration",
+		Tags:        []string{"pre-migration", "mainnet"},
+		Fingerprint: fingerprint("block-42", "before the migration", []string{"pre-migration", "mainnet"}, ""),
+	}
+	state := []byte("pretend this is a serialized storage tree")
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSnapshotArchive(&buf, info, state))
+
+	gotInfo, gotState, err := readSnapshotArchive(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, info, gotInfo)
+	assert.Equal(t, state, gotState)
+}
+
+func TestSnapshotArchiveRoundTripIsDeterministic(t *testing.T) {
+	info := &SnapshotInfo{Name: "n", BlockId: "b"}
+	state := []byte("state")
+
+	var first, second bytes.Buffer
+	require.NoError(t, writeSnapshotArchive(&first, info, state))
+	require.NoError(t, writeSnapshotArchive(&second, info, state))
+
+	assert.Equal(t, first.Bytes(), second.Bytes())
+}
+
+func TestReadSnapshotArchiveAllowsEmptyStateEntry(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeSnapshotArchive(&buf, &SnapshotInfo{Name: "n"}, nil))
+
+	_, _, err := readSnapshotArchive(&buf)
+	require.NoError(t, err, "an empty state.bin entry is still a present entry")
+}
+
+func TestReadSnapshotArchiveRejectsMalformedInput(t *testing.T) {
+	_, _, err := readSnapshotArchive(bytes.NewReader(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,250 @@
+/*
+ * Flow Emulator
+ *
+ * Copyright 2019 Dapper Labs, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket topics that a client can subscribe to.
+const (
+	TopicBlocks       = "blocks"
+	TopicTransactions = "transactions"
+	TopicEvents       = "events"
+)
+
+const (
+	wsWriteTimeout  = 10 * time.Second
+	wsPingPeriod    = 30 * time.Second
+	wsPongWait      = 60 * time.Second
+	wsSendBufferLen = 32
+)
+
+// Frame is the JSON envelope sent to subscribers over the WebSocket
+// connection for every block, transaction, and event notification.
+type Frame struct {
+	Type    string      `json:"type"`
+	Data    interface{} `json:"data"`
+	Height  uint64      `json:"height,omitempty"`
+	BlockId string      `json:"blockId,omitempty"`
+}
+
+// controlMessage is sent by the client to subscribe or unsubscribe from
+// one or more topics after the connection is established.
+type controlMessage struct {
+	Action string   `json:"action"`
+	Topics []string `json:"topics"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriber is a single WebSocket connection along with the topics it
+// currently wants to receive frames for.
+type subscriber struct {
+	conn   *websocket.Conn
+	send   chan Frame
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+func newSubscriber(conn *websocket.Conn) *subscriber {
+	return &subscriber{
+		conn:   conn,
+		send:   make(chan Frame, wsSendBufferLen),
+		topics: map[string]bool{TopicBlocks: true, TopicTransactions: true, TopicEvents: true},
+	}
+}
+
+func (s *subscriber) subscribed(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.topics[topic]
+}
+
+func (s *subscriber) setTopics(topics []string, subscribe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, topic := range topics {
+		if subscribe {
+			s.topics[topic] = true
+		} else {
+			delete(s.topics, topic)
+		}
+	}
+}
+
+// Broker fans out Frames to every subscribed WebSocket connection. It is
+// safe for concurrent use, since block, transaction, and event
+// notifications may originate from different goroutines.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+func (b *Broker) add(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[s] = true
+}
+
+func (b *Broker) remove(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.send)
+	}
+}
+
+// Publish fans a Frame out to every subscriber of the given topic. When a
+// slow consumer's buffered channel is full, its oldest queued frame is
+// dropped to make room rather than blocking the broadcaster; the connection
+// is closed only if the frame still cannot be queued.
+func (b *Broker) Publish(topic string, frame Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		if !s.subscribed(topic) {
+			continue
+		}
+		select {
+		case s.send <- frame:
+		default:
+			// Slow consumer: drop the oldest queued frame to make room
+			// rather than block the broadcaster or grow unbounded.
+			select {
+			case <-s.send:
+			default:
+			}
+			select {
+			case s.send <- frame:
+			default:
+				go s.conn.Close()
+			}
+		}
+	}
+}
+
+func (m EmulatorAPIServer) Websocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	sub := newSubscriber(conn)
+	m.broker.add(sub)
+
+	ctx := r.Context()
+	go m.writePump(ctx, sub)
+	m.readPump(ctx, sub)
+}
+
+// readPump watches ctx alongside the connection: DeadlineMiddleware binds
+// it to the server's shutdown channel for this route, so an idle client
+// that never disconnects on its own still gets its connection closed (and
+// its inflight slot freed) when the server shuts down, instead of holding
+// Shutdown's WaitGroup open forever.
+func (m EmulatorAPIServer) readPump(ctx context.Context, s *subscriber) {
+	defer func() {
+		m.broker.remove(s)
+		_ = s.conn.Close()
+	}()
+
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.conn.Close()
+		case <-stopWatch:
+		}
+	}()
+
+	_ = s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg controlMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Action {
+		case "subscribe":
+			s.setTopics(msg.Topics, true)
+		case "unsubscribe":
+			s.setTopics(msg.Topics, false)
+		}
+	}
+}
+
+func (m EmulatorAPIServer) writePump(ctx context.Context, s *subscriber) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = s.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			_ = s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			return
+		case frame, ok := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				_ = s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := s.conn.WriteJSON(frame); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// registerWebsocketRoutes wires the streaming endpoint into the router.
+// Kept separate from NewEmulatorAPIServer so the broker can be constructed
+// alongside the other routes without cluttering the constructor.
+func registerWebsocketRoutes(router *mux.Router, r *EmulatorAPIServer) {
+	router.HandleFunc("/emulator/ws", r.Websocket)
+}